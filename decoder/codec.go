@@ -0,0 +1,229 @@
+package decoder
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"strconv"
+
+	"github.com/negbie/logp"
+)
+
+var (
+	aRtpmap = []byte("a=rtpmap:")
+	aFmtp   = []byte("a=fmtp:")
+)
+
+// codecInfo is the codec tied to a single RTP payload type, as advertised in
+// an SDP "a=rtpmap:<pt> <name>/<clockrate>" line and, where present, its
+// "a=fmtp:<pt> <params>" companion.
+type codecInfo struct {
+	Name      string `json:"codec_name"`
+	ClockRate int    `json:"clock_rate"`
+	PT        int    `json:"pt"`
+	Fmtp      string `json:"fmtp,omitempty"`
+}
+
+// parsePTMap extracts the PT -> codec mapping advertised by a media section's
+// a=rtpmap: lines, decorated with the matching a=fmtp: parameters where the
+// section has one, keyed by the PT as a decimal string so it can be looked up
+// straight from a wire payload type.
+func parsePTMap(section []byte) map[string]codecInfo {
+	ptMap := make(map[string]codecInfo)
+	for _, line := range bytes.Split(section, []byte("\r\n")) {
+		if !bytes.HasPrefix(line, aRtpmap) {
+			continue
+		}
+		fields := bytes.Fields(line[len(aRtpmap):])
+		if len(fields) < 2 {
+			logp.Debug("sdp", "Fishy a=rtpmap line '%s'", line)
+			continue
+		}
+		pt, err := strconv.Atoi(string(fields[0]))
+		if err != nil {
+			continue
+		}
+		nameRate := bytes.SplitN(fields[1], []byte("/"), 2)
+		if len(nameRate) < 2 {
+			continue
+		}
+		rate, err := strconv.Atoi(string(nameRate[1]))
+		if err != nil {
+			continue
+		}
+		ptMap[string(fields[0])] = codecInfo{Name: string(nameRate[0]), ClockRate: rate, PT: pt}
+	}
+
+	for _, line := range bytes.Split(section, []byte("\r\n")) {
+		if !bytes.HasPrefix(line, aFmtp) {
+			continue
+		}
+		fields := bytes.Fields(line[len(aFmtp):])
+		if len(fields) < 2 {
+			logp.Debug("sdp", "Fishy a=fmtp line '%s'", line)
+			continue
+		}
+		codec, ok := ptMap[string(fields[0])]
+		if !ok {
+			continue
+		}
+		codec.Fmtp = string(bytes.Join(fields[1:], []byte(" ")))
+		ptMap[string(fields[0])] = codec
+	}
+	return ptMap
+}
+
+// cachePTMap stores the PT -> codec mapping of a media section against one
+// (ip, port) endpoint key of that section (cacheSDPIPPort calls it for the
+// RTP port and each ICE candidate endpoint, not for the m= section's RTCP
+// port), so updateRTPPayloadType can
+// later look up the codec a newly-seen SSRC's payload type refers to.
+func cachePTMap(key []byte, ptMap map[string]codecInfo) {
+	if len(ptMap) == 0 {
+		return
+	}
+	data, err := json.Marshal(ptMap)
+	if err != nil {
+		logp.Warn("%v", err)
+		return
+	}
+	codecCache.Set(key, data)
+}
+
+// updateRTPPayloadType records the codec currently used by an SSRC, looking
+// it up from the PT advertised in the SDP for (ip, port) the first time the
+// SSRC is seen, and refreshing rtpPayloadCache whenever the PT on the wire
+// changes. It returns the previously known codec (nil the first time the
+// SSRC is seen) and the current one, so callers can detect a mid-call codec
+// switch, e.g. DTMF telephone-event vs PCMA.
+func updateRTPPayloadType(ssrc []byte, pt byte, ip net.IP, port uint16) (prev, cur *codecInfo) {
+	if ssrc == nil {
+		return nil, nil
+	}
+
+	if raw := rtpPayloadCache.Get(nil, ssrc); raw != nil {
+		var c codecInfo
+		if err := json.Unmarshal(raw, &c); err == nil {
+			prev = &c
+			if c.PT == int(pt) {
+				return prev, prev
+			}
+		}
+	}
+
+	codec := codecInfo{PT: int(pt)}
+	key := []byte(ip.String() + strconv.Itoa(int(port)))
+	if raw := codecCache.Get(nil, key); raw != nil {
+		var ptMap map[string]codecInfo
+		if err := json.Unmarshal(raw, &ptMap); err == nil {
+			if c, ok := ptMap[strconv.Itoa(int(pt))]; ok {
+				codec = c
+			}
+		}
+	}
+
+	data, err := json.Marshal(codec)
+	if err != nil {
+		logp.Warn("%v", err)
+		return prev, nil
+	}
+	rtpPayloadCache.Set(ssrc, data)
+	return prev, &codec
+}
+
+// checkPayloadChange updates the SSRC's tracked codec and, if the PT on the
+// wire just changed, returns a synthetic HEP log event (the same subtype 100
+// correlateLOG uses for Call-ID tagged log lines) describing the switch so
+// Homer surfaces mid-call codec changes the same way it does log-derived
+// events.
+func checkPayloadChange(ssrc []byte, pt byte, ip net.IP, port uint16, callID []byte) []byte {
+	prev, cur := updateRTPPayloadType(ssrc, pt, ip, port)
+	if prev == nil || cur == nil || prev.PT == cur.PT {
+		return nil
+	}
+
+	msg := map[string]interface{}{
+		"callid":     string(callID),
+		"ssrc":       hex.EncodeToString(ssrc),
+		"from_pt":    prev.PT,
+		"from_codec": prev.Name,
+		"to_pt":      cur.PT,
+		"to_codec":   cur.Name,
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		logp.Warn("%v", err)
+		return nil
+	}
+	return data
+}
+
+// extractPT pulls the numeric "pt" field out of an already-serialized RTCP
+// JSON payload, present whenever protos.ParseRTCP decoded payload-type
+// information (e.g. from an RTCP-XR block) for the packet.
+func extractPT(jsonRTCP []byte) (byte, bool) {
+	if jsonRTCP == nil {
+		return 0, false
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(jsonRTCP, &fields); err != nil {
+		return 0, false
+	}
+	raw, ok := fields["pt"]
+	if !ok {
+		return 0, false
+	}
+	n, ok := raw.(float64)
+	if !ok || n < 0 || n > 255 {
+		return 0, false
+	}
+	return byte(n), true
+}
+
+// trackCodec updates ssrc's tracked codec from the PT carried in jsonRTCP
+// and, if the PT just changed mid-call, emits a synthetic HEP log event via
+// EmitHEPEvent so Homer surfaces the switch (e.g. DTMF telephone-event vs
+// PCMA) the same way it does log-derived events. correlateRTP does the same
+// thing directly from the RTP header's PT field, which is where this fires
+// on real traffic; this is a best-effort extra for correlateRTCP, since
+// RTCP itself carries no PT outside an RTCP-XR block.
+func trackCodec(jsonRTCP, ssrc []byte, ip net.IP, port uint16, callID []byte) {
+	pt, ok := extractPT(jsonRTCP)
+	if !ok {
+		return
+	}
+	if event := checkPayloadChange(ssrc, pt, ip, port, callID); event != nil {
+		EmitHEPEvent(HEPEvent{Subtype: 100, JSON: event, CallID: callID})
+	}
+}
+
+// enrichRTCPCodec adds codec_name, clock_rate and pt from the SSRC's tracked
+// codec to an already-serialized RTCP JSON payload.
+func enrichRTCPCodec(jsonRTCP, ssrc []byte) []byte {
+	if jsonRTCP == nil {
+		return nil
+	}
+	raw := rtpPayloadCache.Get(nil, ssrc)
+	if raw == nil {
+		return jsonRTCP
+	}
+	var codec codecInfo
+	if err := json.Unmarshal(raw, &codec); err != nil {
+		return jsonRTCP
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(jsonRTCP, &fields); err != nil {
+		return jsonRTCP
+	}
+	fields["codec_name"] = codec.Name
+	fields["clock_rate"] = codec.ClockRate
+	fields["pt"] = codec.PT
+
+	enriched, err := json.Marshal(fields)
+	if err != nil {
+		return jsonRTCP
+	}
+	return enriched
+}
@@ -0,0 +1,147 @@
+package decoder
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+)
+
+func TestUpdateRTPPayloadTypeCodecSwitch(t *testing.T) {
+	ip := net.ParseIP("203.0.113.50")
+	port := uint16(9000)
+
+	sdp := "v=0\r\n" +
+		"o=- 1 1 IN IP4 203.0.113.50\r\n" +
+		"s=-\r\n" +
+		"c=IN IP4 203.0.113.50\r\n" +
+		"t=0 0\r\n" +
+		"m=audio 9000 RTP/AVP 0 101\r\n" +
+		"a=rtpmap:0 PCMU/8000\r\n" +
+		"a=rtpmap:101 telephone-event/8000\r\n"
+	cacheSDPIPPort(sipPayload("codec-switch-1", sdp))
+
+	ssrc := []byte{0x11, 0x22, 0x33, 0x44}
+
+	prev, cur := updateRTPPayloadType(ssrc, 0, ip, port)
+	if prev != nil {
+		t.Fatalf("expected no previous codec on first sighting, got %+v", prev)
+	}
+	if cur == nil || cur.Name != "PCMU" || cur.ClockRate != 8000 {
+		t.Fatalf("expected PCMU/8000 codec, got %+v", cur)
+	}
+
+	// DTMF mid-call: the same SSRC switches to telephone-event.
+	prev, cur = updateRTPPayloadType(ssrc, 101, ip, port)
+	if prev == nil || prev.Name != "PCMU" {
+		t.Fatalf("expected previous codec PCMU, got %+v", prev)
+	}
+	if cur == nil || cur.Name != "telephone-event" {
+		t.Fatalf("expected current codec telephone-event, got %+v", cur)
+	}
+
+	// End of the DTMF burst: switching back to PCMU raises a codec-change event.
+	event := checkPayloadChange(ssrc, 0, ip, port, []byte("codec-switch-1"))
+	if event == nil {
+		t.Fatal("expected a synthetic codec-change event, got nil")
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(event, &fields); err != nil {
+		t.Fatalf("event is not valid JSON: %v", err)
+	}
+	if fields["from_codec"] != "telephone-event" || fields["to_codec"] != "PCMU" {
+		t.Fatalf("unexpected codec-change event fields: %+v", fields)
+	}
+}
+
+func TestUpdateRTPPayloadTypeSSRCReuseAcrossReinvite(t *testing.T) {
+	ssrc := []byte{0xaa, 0xbb, 0xcc, 0xdd}
+	ip := net.ParseIP("203.0.113.60")
+
+	initial := "v=0\r\n" +
+		"o=- 1 1 IN IP4 203.0.113.60\r\n" +
+		"s=-\r\n" +
+		"c=IN IP4 203.0.113.60\r\n" +
+		"t=0 0\r\n" +
+		"m=audio 9100 RTP/AVP 0\r\n" +
+		"a=rtpmap:0 PCMU/8000\r\n"
+	cacheSDPIPPort(sipPayload("reinvite-ssrc-1", initial))
+	if _, cur := updateRTPPayloadType(ssrc, 0, ip, 9100); cur == nil || cur.Name != "PCMU" {
+		t.Fatalf("expected PCMU before re-INVITE, got %+v", cur)
+	}
+
+	// Re-INVITE renegotiates the same SSRC onto a different port and codec.
+	reinvite := "v=0\r\n" +
+		"o=- 1 2 IN IP4 203.0.113.60\r\n" +
+		"s=-\r\n" +
+		"c=IN IP4 203.0.113.60\r\n" +
+		"t=0 0\r\n" +
+		"m=audio 9200 RTP/AVP 8\r\n" +
+		"a=rtpmap:8 PCMA/8000\r\n"
+	cacheSDPIPPort(sipPayload("reinvite-ssrc-1", reinvite))
+
+	prev, cur := updateRTPPayloadType(ssrc, 8, ip, 9200)
+	if prev == nil || prev.Name != "PCMU" {
+		t.Fatalf("expected previous codec PCMU across re-INVITE, got %+v", prev)
+	}
+	if cur == nil || cur.Name != "PCMA" {
+		t.Fatalf("expected current codec PCMA after re-INVITE, got %+v", cur)
+	}
+}
+
+// TestTrackCodecProductionWiring drives trackCodec - the call correlateRTCP
+// makes for every correlated report - with a jsonRTCP carrying a "pt" field
+// for a known SSRC, as an RTCP-XR block would, and EmitHEPEvent stubbed to
+// capture whatever gets raised upstream. correlateRTP reaches the same
+// checkPayloadChange/EmitHEPEvent path straight from the RTP header's PT.
+func TestTrackCodecProductionWiring(t *testing.T) {
+	ip := net.ParseIP("203.0.113.70")
+	port := uint16(9300)
+	callID := []byte("trackcodec-wiring-1")
+
+	sdp := "v=0\r\n" +
+		"o=- 1 1 IN IP4 203.0.113.70\r\n" +
+		"s=-\r\n" +
+		"c=IN IP4 203.0.113.70\r\n" +
+		"t=0 0\r\n" +
+		"m=audio 9300 RTP/AVP 0 101\r\n" +
+		"a=rtpmap:0 PCMU/8000\r\n" +
+		"a=rtpmap:101 telephone-event/8000\r\n"
+	cacheSDPIPPort(sipPayload(string(callID), sdp))
+
+	ssrc := []byte{0xde, 0xad, 0xbe, 0xef}
+
+	prevEmit := EmitHEPEvent
+	defer func() { EmitHEPEvent = prevEmit }()
+	var emitted []HEPEvent
+	EmitHEPEvent = func(ev HEPEvent) { emitted = append(emitted, ev) }
+
+	// First report on this SSRC just establishes the baseline codec; no
+	// prior PT is known yet, so no event should fire.
+	trackCodec(rtcpPTJSON(0), ssrc, ip, port, callID)
+	if len(emitted) != 0 {
+		t.Fatalf("expected no event on first sighting, got %+v", emitted)
+	}
+
+	// Mid-call DTMF: the SSRC's PT switches to telephone-event.
+	trackCodec(rtcpPTJSON(101), ssrc, ip, port, callID)
+	if len(emitted) != 1 {
+		t.Fatalf("expected exactly one synthetic event on PT switch, got %+v", emitted)
+	}
+	if emitted[0].Subtype != 100 || string(emitted[0].CallID) != "trackcodec-wiring-1" {
+		t.Fatalf("unexpected event envelope: %+v", emitted[0])
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(emitted[0].JSON, &fields); err != nil {
+		t.Fatalf("event is not valid JSON: %v", err)
+	}
+	if fields["from_codec"] != "PCMU" || fields["to_codec"] != "telephone-event" {
+		t.Fatalf("unexpected codec-change event fields: %+v", fields)
+	}
+}
+
+// rtcpPTJSON builds the minimal jsonRTCP shape trackCodec's extractPT parses,
+// standing in for the real RTCP JSON protos.ParseRTCP would have produced.
+func rtcpPTJSON(pt byte) []byte {
+	data, _ := json.Marshal(map[string]interface{}{"pt": pt})
+	return data
+}
@@ -1,7 +1,6 @@
 package decoder
 
 import (
-	"bytes"
 	"encoding/json"
 	"net"
 	"strconv"
@@ -12,89 +11,20 @@ import (
 )
 
 var (
-	ipPort    bytes.Buffer
-	cLine     = []byte("c=IN IP")
-	mLine     = []byte("m=audio ")
-	aLine     = []byte("a=rtcp:")
-	sdpCache  = fastcache.New(30 * 1024 * 1024)
-	rtcpCache = fastcache.New(30 * 1024 * 1024)
+	sdpCache        = fastcache.New(30 * 1024 * 1024)
+	rtcpCache       = fastcache.New(30 * 1024 * 1024)
+	codecCache      = fastcache.New(10 * 1024 * 1024)
+	rtpPayloadCache = fastcache.New(10 * 1024 * 1024)
 )
 
-// cacheSDPIPPort will extract the source IP, source Port from SDP body and CallID from SIP header.
-// It will do this only for SIP messages which have the strings "c=IN IP4 " and "m=audio " in the SDP body.
-// If there is one rtcp attribute in the SDP body it will use it as RTCP port. Otherwise it will add 1 to
-// the RTP source port. These data will be used for the SDPCache as key:value pairs.
-func cacheSDPIPPort(payload []byte) {
-	if posSDPIP := bytes.Index(payload, cLine); posSDPIP > 0 {
-		if posSDPPort := bytes.Index(payload, mLine); posSDPPort > 0 {
-			ipPort.Reset()
-			restIP := payload[posSDPIP:]
-			// Minimum IPv4 length of "c=IN IP4 1.1.1.1" = 16
-			if posRestIP := bytes.Index(restIP, []byte("\r\n")); posRestIP >= 16 {
-				ipPort.Write(restIP[len(cLine)+2 : posRestIP])
-			} else {
-				logp.Debug("sdp", "No end or fishy SDP IP in '%s'", restIP)
-				return
-			}
-
-			if posRTCPPort := bytes.Index(payload, aLine); posRTCPPort > 0 {
-				restRTCPPort := payload[posRTCPPort:]
-				// Minimum RTCP port length of "a=rtcp:1000" = 11
-				if posRestRTCPPort := bytes.Index(restRTCPPort, []byte("\r\n")); posRestRTCPPort >= 11 && posRestRTCPPort < 14 {
-					ipPort.Write(restRTCPPort[len(aLine):posRestRTCPPort])
-				} else if posRestRTCPPort := bytes.IndexRune(restRTCPPort, ' '); posRestRTCPPort >= 11 {
-					ipPort.Write(restRTCPPort[len(aLine):posRestRTCPPort])
-				} else {
-					logp.Debug("sdp", "No end or fishy SDP RTCP Port in '%s'", restRTCPPort)
-					return
-				}
-			} else {
-				restPort := payload[posSDPPort:]
-				// Minimum RTCP port length of "m=audio 1000" = 12
-				if posRestPort := bytes.Index(restPort, []byte(" RTP")); posRestPort >= 12 {
-					ipPort.Write(restPort[len(mLine):posRestPort])
-					lastNum := len(ipPort.Bytes()) - 1
-					ipPort.Bytes()[lastNum] = byte(uint32(ipPort.Bytes()[lastNum]) + 1)
-				} else {
-					logp.Debug("sdp", "No end or fishy SDP RTP Port in '%s'", restPort)
-					return
-				}
-			}
-
-			var callID []byte
-			if posCallID := bytes.Index(payload, []byte("Call-I")); posCallID > 0 {
-				restCallID := payload[posCallID:]
-				// Minimum Call-ID length of "Call-ID: a" = 10
-				if posRestCallID := bytes.Index(restCallID, []byte("\r\n")); posRestCallID >= 10 {
-					callID = restCallID[len("Call-ID:"):posRestCallID]
-				} else {
-					logp.Debug("sdp", "No end or fishy Call-ID in '%s'", restCallID)
-					return
-				}
-			} else if posID := bytes.Index(payload, []byte("i: ")); posID > 0 {
-				restID := payload[posID:]
-				// Minimum Call-ID length of "i: a" = 4
-				if posRestID := bytes.Index(restID, []byte("\r\n")); posRestID >= 4 {
-					callID = restID[len("i: "):posRestID]
-				} else {
-					logp.Debug("sdp", "No end or fishy Call-ID in '%s'", restID)
-					return
-				}
-			} else {
-				logp.Warn("No Call-ID in '%s'", payload)
-				return
-			}
-
-			//logp.Debug("sdp", "Add to SDPCache key=%s, value=%s", ipPort.String(), string(callID))
-			sdpCache.Set(ipPort.Bytes(), bytes.TrimSpace(callID))
-		}
-	}
-}
-
 // correlateRTCP will try to correlate RTCP data with SIP messages.
 // First it will look inside the longlive RTCPCache with the ssrc as key.
 // If it can't find a value it will look inside the shortlive SDPCache with (SDPIP+RTCPPort) as key.
 // If it finds a value inside the SDPCache it will add it to the RTCPCache with the ssrc as key.
+// On a match the JSON is enriched with the codec_name, clock_rate and pt of the
+// SSRC's currently known payload type, tracked in rtpPayloadCache by
+// correlateRTP on the matching RTP stream (trackCodec here is a best-effort
+// extra for the rare RTCP-XR block that carries its own "pt" field).
 func correlateRTCP(srcIP net.IP, srcPort uint16, dstIP net.IP, dstPort uint16, payload []byte) ([]byte, []byte) {
 
 	keyRTCP, jsonRTCP, info := protos.ParseRTCP(payload)
@@ -109,7 +39,8 @@ func correlateRTCP(srcIP net.IP, srcPort uint16, dstIP net.IP, dstPort uint16, p
 	if corrID := rtcpCache.Get(nil, keyRTCP); corrID != nil && keyRTCP != nil {
 		logp.Debug("rtcp", "Found '%x:%s' in RTCPCache srcIP=%s, srcPort=%d, dstIP=%s, dstPort=%d, payload=%s",
 			keyRTCP, corrID, srcIP, srcPort, dstIP, dstPort, jsonRTCP)
-		return jsonRTCP, corrID
+		trackCodec(jsonRTCP, keyRTCP, srcIP, srcPort, corrID)
+		return enrichRTCPCodec(jsonRTCP, keyRTCP), corrID
 	}
 
 	srcIPString := srcIP.String()
@@ -119,7 +50,8 @@ func correlateRTCP(srcIP net.IP, srcPort uint16, dstIP net.IP, dstPort uint16, p
 		logp.Debug("rtcp", "Found '%s:%s' in SDPCache srcIP=%s, srcPort=%s, payload=%s",
 			srcKey, corrID, srcIPString, srcPortString, jsonRTCP)
 		rtcpCache.Set(keyRTCP, corrID)
-		return jsonRTCP, corrID
+		trackCodec(jsonRTCP, keyRTCP, srcIP, srcPort, corrID)
+		return enrichRTCPCodec(jsonRTCP, keyRTCP), corrID
 	}
 
 	dstIPString := dstIP.String()
@@ -129,52 +61,66 @@ func correlateRTCP(srcIP net.IP, srcPort uint16, dstIP net.IP, dstPort uint16, p
 		logp.Debug("rtcp", "Found '%s:%s' in SDPCache dstIP=%s, dstPort=%s, payload=%s",
 			dstKey, corrID, dstIPString, dstPortString, jsonRTCP)
 		rtcpCache.Set(keyRTCP, corrID)
-		return jsonRTCP, corrID
+		trackCodec(jsonRTCP, keyRTCP, dstIP, dstPort, corrID)
+		return enrichRTCPCodec(jsonRTCP, keyRTCP), corrID
 	}
 
 	logp.Debug("rtcp", "No correlationID for srcIP=%s, srcPort=%s, dstIP=%s, dstPort=%s, payload=%s",
 		srcIPString, srcPortString, dstIPString, dstPortString, jsonRTCP)
+	bufferPendingRTCP(keyRTCP, jsonRTCP, srcIP, srcPort, dstIP, dstPort)
 	return nil, nil
 }
 
-func correlateLOG(payload []byte) (byte, []byte) {
-	var callID []byte
-	if posID := bytes.Index(payload, []byte("ID=")); posID > 0 {
-		restID := payload[posID:]
-		// Minimum Call-ID length of "ID=a" = 4
-		if posRestID := bytes.IndexRune(restID, ' '); posRestID >= 4 {
-			callID = restID[len("ID="):posRestID]
-		} else if len(restID) > 4 && len(restID) < 80 {
-			callID = restID[3:]
-		} else {
-			logp.Debug("log", "No end or fishy Call-ID in '%s'", restID)
-			return 0, nil
-		}
-		if callID != nil {
-			logp.Debug("log", "Found CallID: %s in Logline: '%s'", callID, payload)
-			return 100, callID
+// correlateRTP tracks the payload type carried by an RTP packet against its
+// SSRC, the actual production entry point for the mid-call codec-switch
+// detection checkPayloadChange implements. Unlike correlateRTCP's
+// trackCodec/extractPT call, which only fires on the rare RTCP-XR block that
+// happens to carry a "pt" field, every RTP packet's PT is right there in the
+// fixed header, so this is what keeps rtpPayloadCache populated on real
+// traffic. It looks up the Call-ID the same way correlateRTCP does - first
+// the long-lived rtcpCache by SSRC, then the short-lived sdpCache by
+// (ip, port) - since an RTP stream's own SSRC isn't known until some RTCP or
+// SDP has already correlated it.
+func correlateRTP(srcIP net.IP, srcPort uint16, dstIP net.IP, dstPort uint16, payload []byte) {
+	ssrc, pt, info := protos.ParseRTP(payload)
+	if info != "" {
+		logp.Debug("rtp", "ssrc=%x, pt=%d, srcIP=%s, srcPort=%d, %s", ssrc, pt, srcIP, srcPort, info)
+	}
+	if ssrc == nil {
+		return
+	}
 
+	callID := rtcpCache.Get(nil, ssrc)
+	if callID == nil {
+		srcKey := []byte(srcIP.String() + strconv.Itoa(int(srcPort)))
+		callID = sdpCache.Get(nil, srcKey)
+	}
+	if callID == nil {
+		dstKey := []byte(dstIP.String() + strconv.Itoa(int(dstPort)))
+		callID = sdpCache.Get(nil, dstKey)
+	}
+	if callID == nil {
+		return
+	}
+
+	if event := checkPayloadChange(ssrc, pt, srcIP, srcPort, callID); event != nil {
+		EmitHEPEvent(HEPEvent{Subtype: 100, JSON: event, CallID: callID})
+	}
+}
+
+// correlateLOG evaluates logRules in order and returns the HEP subtype and
+// Call-ID of the first one that matches payload. logRules starts out as just
+// the hardcoded fast paths and grows to cover Asterisk/Kamailio/FreeSWITCH/
+// RFC 5424 log shapes - or custom patterns - once InitLogRules has loaded
+// -log-rules.
+func correlateLOG(payload []byte) (byte, []byte) {
+	for _, rule := range logRules {
+		callID := rule.match(payload)
+		if callID == nil {
+			continue
 		}
-	} else if posID := bytes.Index(payload, []byte(": [")); posID > 0 {
-		restID := payload[posID:]
-		if posRestID := bytes.Index(restID, []byte(" port ")); posRestID >= 8 {
-			callID = restID[len(": ["):posRestID]
-		} else if posRestID := bytes.Index(restID, []byte("]: ")); posRestID >= 4 {
-			callID = restID[len(": ["):posRestID]
-		} else {
-			logp.Debug("log", "No end or fishy Call-ID in '%s'", restID)
-			return 0, nil
-		}
-		if len(callID) > 4 && len(callID) < 80 {
-			logp.Debug("log", "Found CallID: %s in Logline: '%s'", callID, payload)
-			return 100, callID
-		}
-	} else if ap := bytes.Index(payload, []byte("alert")); ap > -1 {
-		return 112, []byte("alert")
-	} else if wp := bytes.Index(payload, []byte("WARN")); wp > -1 {
-		return 112, []byte("warning")
-	} else if ep := bytes.Index(payload, []byte("ERR")); ep > -1 {
-		return 112, []byte("error")
+		logp.Debug("log", "Found CallID: %s in Logline: '%s' via rule '%s'", callID, payload, rule.name)
+		return rule.subtype, callID
 	}
 	return 0, nil
 }
@@ -0,0 +1,103 @@
+package decoder
+
+import "testing"
+
+func hasCandidate(cands []iceCandidate, ip, port string) bool {
+	for _, c := range cands {
+		if string(c.ip) == ip && string(c.port) == port {
+			return true
+		}
+	}
+	return false
+}
+
+func TestParseICECandidatesHost(t *testing.T) {
+	section := "m=audio 9 UDP/TLS/RTP/SAVPF 0\r\n" +
+		"a=candidate:1 1 udp 2130706431 192.0.2.10 50000 typ host\r\n"
+
+	cands := parseICECandidates([]byte(section))
+	if !hasCandidate(cands, "192.0.2.10", "50000") {
+		t.Fatalf("expected the host candidate's own (ip, port), got %+v", cands)
+	}
+	if !hasCandidate(cands, "192.0.2.10", "50001") {
+		t.Fatalf("expected the component=1 RTCP companion at port+1, got %+v", cands)
+	}
+	if len(cands) != 2 {
+		t.Fatalf("expected exactly 2 candidates, got %+v", cands)
+	}
+}
+
+func TestParseICECandidatesRelayComponentTwoHasNoCompanion(t *testing.T) {
+	section := "m=audio 9 UDP/TLS/RTP/SAVPF 0\r\n" +
+		"a=candidate:3 2 udp 1694498815 198.51.100.20 50002 typ relay raddr 192.0.2.10 rport 50000\r\n"
+
+	cands := parseICECandidates([]byte(section))
+	if !hasCandidate(cands, "198.51.100.20", "50002") {
+		t.Fatalf("expected the relay candidate's own (ip, port), got %+v", cands)
+	}
+	if !hasCandidate(cands, "192.0.2.10", "50000") {
+		t.Fatalf("expected the raddr/rport reflexive pair, got %+v", cands)
+	}
+	// component=2 is already the RTCP channel, so neither pair gets a +1 companion.
+	if len(cands) != 2 {
+		t.Fatalf("expected exactly 2 candidates (no +1 companions for component=2), got %+v", cands)
+	}
+}
+
+func TestParseICECandidatesSrflxReflexivePair(t *testing.T) {
+	section := "m=audio 9 UDP/TLS/RTP/SAVPF 0\r\n" +
+		"a=candidate:2 1 udp 1694498815 203.0.113.30 60000 typ srflx raddr 203.0.113.31 rport 60002\r\n"
+
+	cands := parseICECandidates([]byte(section))
+	for _, want := range [][2]string{
+		{"203.0.113.30", "60000"}, {"203.0.113.30", "60001"},
+		{"203.0.113.31", "60002"}, {"203.0.113.31", "60003"},
+	} {
+		if !hasCandidate(cands, want[0], want[1]) {
+			t.Errorf("expected candidate %s:%s, got %+v", want[0], want[1], cands)
+		}
+	}
+	if len(cands) != 4 {
+		t.Fatalf("expected the srflx candidate's own pair plus its raddr/rport reflexive pair, got %+v", cands)
+	}
+}
+
+func TestParseICECandidatesRTCPMuxSuppressesCompanion(t *testing.T) {
+	section := "m=audio 9 UDP/TLS/RTP/SAVPF 0\r\n" +
+		"a=rtcp-mux\r\n" +
+		"a=candidate:1 1 udp 2130706431 192.0.2.10 50000 typ host\r\n"
+
+	cands := parseICECandidates([]byte(section))
+	if !hasCandidate(cands, "192.0.2.10", "50000") {
+		t.Fatalf("expected the host candidate's own (ip, port), got %+v", cands)
+	}
+	if hasCandidate(cands, "192.0.2.10", "50001") {
+		t.Fatalf("rtcp-mux should suppress the port+1 companion, got %+v", cands)
+	}
+	if len(cands) != 1 {
+		t.Fatalf("expected exactly 1 candidate under rtcp-mux, got %+v", cands)
+	}
+}
+
+func TestCacheSDPIPPortCachesICECandidates(t *testing.T) {
+	sdp := "v=0\r\n" +
+		"o=- 1 1 IN IP4 192.0.2.10\r\n" +
+		"s=-\r\n" +
+		"c=IN IP4 192.0.2.10\r\n" +
+		"t=0 0\r\n" +
+		"m=audio 50000 UDP/TLS/RTP/SAVPF 0\r\n" +
+		"a=rtcp-mux\r\n" +
+		"a=rtpmap:0 PCMU/8000\r\n" +
+		"a=candidate:1 1 udp 2130706431 192.0.2.10 50000 typ host\r\n" +
+		"a=candidate:2 1 udp 1694498815 203.0.113.30 60000 typ srflx raddr 203.0.113.31 rport 60002\r\n"
+
+	cacheSDPIPPort(sipPayload("ice-candidates-1", sdp))
+
+	// These addresses only ever appear in a=candidate: lines, never in the
+	// c=/m= lines, so they can only be cached via ICE candidate harvesting.
+	for _, key := range []string{"203.0.113.3060000", "203.0.113.3160002"} {
+		if got := sdpCache.Get(nil, []byte(key)); string(got) != "ice-candidates-1" {
+			t.Errorf("ICE candidate endpoint %s not cached to ice-candidates-1, got %q", key, got)
+		}
+	}
+}
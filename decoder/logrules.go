@@ -0,0 +1,201 @@
+package decoder
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"regexp"
+	"strings"
+
+	"github.com/negbie/logp"
+)
+
+var logRulesSpec string
+
+func init() {
+	flag.StringVar(&logRulesSpec, "log-rules", "",
+		"Comma separated list of built-in log rule sets (asterisk, kamailio, freeswitch, rfc5424) "+
+			"and/or paths to JSON rule files, evaluated after the default rule set")
+}
+
+// logRule maps a log line shape to the Call-ID it carries and the HEP
+// subtype matching lines should be tagged with. match returns nil when the
+// rule doesn't apply to payload.
+type logRule struct {
+	name    string
+	subtype byte
+	match   func(payload []byte) []byte
+}
+
+// logRules is the ordered list correlateLOG evaluates, first match wins. It
+// starts out as exactly the hardcoded fast paths correlateLOG always had, so
+// behavior is unchanged until InitLogRules is told to load more via
+// -log-rules.
+var logRules = append([]logRule{}, defaultLogRules...)
+
+// defaultLogRules are the original hardcoded correlateLOG fast paths,
+// preserved verbatim as the default rule set.
+var defaultLogRules = []logRule{
+	{name: "id-equals", subtype: 100, match: matchIDEquals},
+	{name: "bracket-id", subtype: 100, match: matchBracketID},
+	{name: "alert", subtype: 112, match: matchSubstring([]byte("alert"), []byte("alert"))},
+	{name: "warn", subtype: 112, match: matchSubstring([]byte("WARN"), []byte("warning"))},
+	{name: "error", subtype: 112, match: matchSubstring([]byte("ERR"), []byte("error"))},
+}
+
+// ruleSpec is the JSON shape of a user-supplied rule file entry: a regexp
+// whose first capture group yields the Call-ID, paired with an HEP subtype.
+type ruleSpec struct {
+	Name    string `json:"name"`
+	Pattern string `json:"pattern"`
+	Subtype byte   `json:"subtype"`
+}
+
+// builtinRuleSets ships ready-made rule sets for the log shapes of common
+// SIP servers plus RFC 5424 structured data, selectable by name via
+// -log-rules without having to hand-write the regexps.
+var builtinRuleSets = map[string][]ruleSpec{
+	"asterisk": {
+		{Name: "asterisk-channel", Pattern: `\[C-([0-9a-fA-F]+)\]`, Subtype: 100},
+	},
+	"kamailio": {
+		{Name: "kamailio-callid", Pattern: `callid=([^\s,;]+)`, Subtype: 100},
+	},
+	"freeswitch": {
+		{Name: "freeswitch-uuid", Pattern: `\[([0-9a-fA-F-]{36})\]`, Subtype: 100},
+	},
+	"rfc5424": {
+		{Name: "rfc5424-sdid", Pattern: `callid@\d+\s+id="([^"]+)"`, Subtype: 100},
+	},
+}
+
+// matchIDEquals extracts the value of an "ID=<callid>" token.
+func matchIDEquals(payload []byte) []byte {
+	posID := bytes.Index(payload, []byte("ID="))
+	if posID <= 0 {
+		return nil
+	}
+	restID := payload[posID:]
+	// Minimum Call-ID length of "ID=a" = 4
+	if posRestID := bytes.IndexRune(restID, ' '); posRestID >= 4 {
+		return restID[len("ID="):posRestID]
+	}
+	if len(restID) > 4 && len(restID) < 80 {
+		return restID[3:]
+	}
+	logp.Debug("log", "No end or fishy Call-ID in '%s'", restID)
+	return nil
+}
+
+// matchBracketID extracts the value of a ": [<callid>] port " or
+// ": [<callid>]: " token.
+func matchBracketID(payload []byte) []byte {
+	// An "ID=" token past the first byte belongs to matchIDEquals alone, even
+	// when it yields no Call-ID.
+	if bytes.Index(payload, []byte("ID=")) > 0 {
+		return nil
+	}
+	posID := bytes.Index(payload, []byte(": ["))
+	if posID <= 0 {
+		return nil
+	}
+	restID := payload[posID:]
+	var callID []byte
+	if posRestID := bytes.Index(restID, []byte(" port ")); posRestID >= 8 {
+		callID = restID[len(": ["):posRestID]
+	} else if posRestID := bytes.Index(restID, []byte("]: ")); posRestID >= 4 {
+		callID = restID[len(": ["):posRestID]
+	} else {
+		logp.Debug("log", "No end or fishy Call-ID in '%s'", restID)
+		return nil
+	}
+	if len(callID) > 4 && len(callID) < 80 {
+		return callID
+	}
+	return nil
+}
+
+// matchSubstring returns a rule matcher that yields a fixed value whenever
+// needle appears anywhere in payload, used for the severity-keyword rules
+// that don't carry a Call-ID at all. It yields nil for a payload with an
+// "ID=" or ": [" token past its first byte, which the Call-ID rules own.
+func matchSubstring(needle, value []byte) func([]byte) []byte {
+	return func(payload []byte) []byte {
+		if bytes.Index(payload, []byte("ID=")) > 0 || bytes.Index(payload, []byte(": [")) > 0 {
+			return nil
+		}
+		if bytes.Index(payload, needle) > -1 {
+			return value
+		}
+		return nil
+	}
+}
+
+// compileRule turns a ruleSpec into a logRule backed by a compiled regexp
+// whose first capture group is the Call-ID.
+func compileRule(spec ruleSpec) (logRule, error) {
+	re, err := regexp.Compile(spec.Pattern)
+	if err != nil {
+		return logRule{}, err
+	}
+	return logRule{
+		name:    spec.Name,
+		subtype: spec.Subtype,
+		match: func(payload []byte) []byte {
+			m := re.FindSubmatch(payload)
+			if len(m) < 2 {
+				return nil
+			}
+			return m[1]
+		},
+	}, nil
+}
+
+// InitLogRules loads the rule sets named in -log-rules (built-in names or
+// paths to a JSON file of ruleSpecs) and appends them to logRules after the
+// default fast paths, so correlateLOG tries Asterisk/Kamailio/FreeSWITCH/
+// RFC 5424 shapes - or whatever custom rules were supplied - only once flags
+// have been parsed. It is a no-op when -log-rules wasn't set.
+func InitLogRules() error {
+	if logRulesSpec == "" {
+		return nil
+	}
+
+	rules := append([]logRule{}, defaultLogRules...)
+	for _, entry := range strings.Split(logRulesSpec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if specs, ok := builtinRuleSets[entry]; ok {
+			for _, spec := range specs {
+				rule, err := compileRule(spec)
+				if err != nil {
+					return err
+				}
+				rules = append(rules, rule)
+			}
+			continue
+		}
+
+		data, err := ioutil.ReadFile(entry)
+		if err != nil {
+			return err
+		}
+		var specs []ruleSpec
+		if err := json.Unmarshal(data, &specs); err != nil {
+			return err
+		}
+		for _, spec := range specs {
+			rule, err := compileRule(spec)
+			if err != nil {
+				return err
+			}
+			rules = append(rules, rule)
+		}
+	}
+
+	logRules = rules
+	return nil
+}
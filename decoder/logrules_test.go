@@ -0,0 +1,140 @@
+package decoder
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCompileRuleMatchesFirstCaptureGroup(t *testing.T) {
+	rule, err := compileRule(ruleSpec{Name: "test-rule", Pattern: `id=([a-z0-9-]+)`, Subtype: 100})
+	if err != nil {
+		t.Fatalf("compileRule returned an error: %v", err)
+	}
+	if got := rule.match([]byte("some log line id=abc-123 trailer")); string(got) != "abc-123" {
+		t.Fatalf("expected captured Call-ID 'abc-123', got %q", got)
+	}
+	if got := rule.match([]byte("no id here")); got != nil {
+		t.Fatalf("expected no match, got %q", got)
+	}
+}
+
+func TestCompileRuleInvalidPattern(t *testing.T) {
+	if _, err := compileRule(ruleSpec{Name: "bad", Pattern: `(unterminated`, Subtype: 100}); err == nil {
+		t.Fatal("expected an error compiling an invalid regexp")
+	}
+}
+
+func builtinRuleFor(t *testing.T, set, name string) logRule {
+	t.Helper()
+	for _, spec := range builtinRuleSets[set] {
+		if spec.Name != name {
+			continue
+		}
+		rule, err := compileRule(spec)
+		if err != nil {
+			t.Fatalf("failed to compile builtin rule %s/%s: %v", set, name, err)
+		}
+		return rule
+	}
+	t.Fatalf("no builtin rule named %s in set %s", name, set)
+	return logRule{}
+}
+
+func TestBuiltinRuleSetAsterisk(t *testing.T) {
+	rule := builtinRuleFor(t, "asterisk", "asterisk-channel")
+	line := []byte(`[2026-07-27 10:00:00] VERBOSE[1234] chan_sip.c: [C-0000001a] Got SIP response`)
+	if got := rule.match(line); string(got) != "0000001a" {
+		t.Fatalf("expected Asterisk channel id '0000001a', got %q", got)
+	}
+}
+
+func TestBuiltinRuleSetKamailio(t *testing.T) {
+	rule := builtinRuleFor(t, "kamailio", "kamailio-callid")
+	line := []byte(`INFO: tm [t_lookup.c:614]: t_lookup_request(): callid=abc123@192.168.1.1, cseq=1 INVITE`)
+	if got := rule.match(line); string(got) != "abc123@192.168.1.1" {
+		// The pattern stops at the first comma/semicolon/space, which
+		// Kamailio log lines always use to delimit the callid token.
+		t.Fatalf("unexpected Kamailio callid match: %q", got)
+	}
+}
+
+func TestBuiltinRuleSetFreeswitch(t *testing.T) {
+	rule := builtinRuleFor(t, "freeswitch", "freeswitch-uuid")
+	line := []byte(`2026-07-27 10:00:00.000000 [NOTICE] switch_core_state_machine.c:672 [aaaaaaaa-bbbb-cccc-dddd-eeeeeeeeeeee] Channel is hungup`)
+	want := "aaaaaaaa-bbbb-cccc-dddd-eeeeeeeeeeee"
+	if got := rule.match(line); string(got) != want {
+		t.Fatalf("expected FreeSWITCH UUID %q, got %q", want, got)
+	}
+}
+
+func TestBuiltinRuleSetRFC5424(t *testing.T) {
+	rule := builtinRuleFor(t, "rfc5424", "rfc5424-sdid")
+	line := []byte(`<34>1 2026-07-27T10:00:00Z host app 1234 ID47 [callid@32473 id="xyz-call-1"] log message`)
+	if got := rule.match(line); string(got) != "xyz-call-1" {
+		t.Fatalf("expected RFC 5424 SD-ID callid 'xyz-call-1', got %q", got)
+	}
+}
+
+func TestInitLogRulesLoadsBuiltinByName(t *testing.T) {
+	prevSpec, prevRules := logRulesSpec, logRules
+	defer func() { logRulesSpec, logRules = prevSpec, prevRules }()
+
+	logRulesSpec = "asterisk"
+	if err := InitLogRules(); err != nil {
+		t.Fatalf("InitLogRules returned an error: %v", err)
+	}
+
+	if len(logRules) != len(defaultLogRules)+1 {
+		t.Fatalf("expected the default rules plus 1 builtin rule, got %d rules", len(logRules))
+	}
+	subtype, callID := correlateLOG([]byte(`chan_sip.c: [C-0000002b] Reliably Transmitting`))
+	if subtype != 100 || string(callID) != "0000002b" {
+		t.Fatalf("expected the loaded Asterisk rule to match via correlateLOG, got subtype=%d callID=%q", subtype, callID)
+	}
+}
+
+func TestInitLogRulesLoadsJSONFile(t *testing.T) {
+	prevSpec, prevRules := logRulesSpec, logRules
+	defer func() { logRulesSpec, logRules = prevSpec, prevRules }()
+
+	path := filepath.Join(t.TempDir(), "rules.json")
+	const contents = `[{"name":"custom","pattern":"custom-id=([a-zA-Z0-9]+)","subtype":100}]`
+	if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+		t.Fatalf("failed to write rule file: %v", err)
+	}
+
+	logRulesSpec = path
+	if err := InitLogRules(); err != nil {
+		t.Fatalf("InitLogRules returned an error: %v", err)
+	}
+
+	subtype, callID := correlateLOG([]byte("some log line custom-id=abcXYZ123 trailer"))
+	if subtype != 100 || string(callID) != "abcXYZ123" {
+		t.Fatalf("expected the custom JSON rule to match via correlateLOG, got subtype=%d callID=%q", subtype, callID)
+	}
+}
+
+func TestInitLogRulesUnknownFilePath(t *testing.T) {
+	prevSpec, prevRules := logRulesSpec, logRules
+	defer func() { logRulesSpec, logRules = prevSpec, prevRules }()
+
+	logRulesSpec = filepath.Join(t.TempDir(), "does-not-exist.json")
+	if err := InitLogRules(); err == nil {
+		t.Fatal("expected an error for a rule set name that is neither a builtin nor a readable file")
+	}
+}
+
+func TestInitLogRulesNoOpWhenUnset(t *testing.T) {
+	prevSpec, prevRules := logRulesSpec, logRules
+	defer func() { logRulesSpec, logRules = prevSpec, prevRules }()
+
+	logRulesSpec = ""
+	logRules = append([]logRule{}, defaultLogRules...)
+	if err := InitLogRules(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(logRules) != len(defaultLogRules) {
+		t.Fatalf("expected logRules to stay unchanged, got %d rules", len(logRules))
+	}
+}
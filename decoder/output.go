@@ -0,0 +1,22 @@
+package decoder
+
+import "github.com/negbie/logp"
+
+// HEPEvent is a decoder-originated HEP payload that isn't the direct,
+// synchronous result of a single captured packet - a late RTCP correlation
+// from the reconciliation sweep, or a mid-call codec switch - paired with
+// the HEP subtype and Call-ID it belongs to.
+type HEPEvent struct {
+	Subtype byte
+	JSON    []byte
+	CallID  []byte
+}
+
+// EmitHEPEvent delivers a synthetic HEPEvent to whatever ships HEP packets
+// upstream. It defaults to structured logging so these events are always
+// observable even before a dedicated sender is wired in; real deployments
+// override it to feed the same output path used for the synchronous
+// correlate* results.
+var EmitHEPEvent = func(ev HEPEvent) {
+	logp.Info("decoder", "subtype=%d callid=%s payload=%s", ev.Subtype, ev.CallID, ev.JSON)
+}
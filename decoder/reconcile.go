@@ -0,0 +1,158 @@
+package decoder
+
+import (
+	"flag"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/negbie/logp"
+)
+
+// pendingRTCPCap bounds the number of un-correlated RTCP streams tracked at
+// once so a flood of orphaned SSRCs (e.g. a scanner) can't grow pendingRTCP
+// without limit; the oldest entry is evicted to make room for a new one.
+const pendingRTCPCap = 1024
+
+// rtcpReconcileInterval and rtcpReconcileTTL are read fresh on every
+// reconciliation cycle (see runRTCPReconciler) rather than captured once, so
+// that -rtcp-reconcile-interval/-rtcp-reconcile-ttl take effect even though
+// the reconciler goroutine is started from this file's init, before main has
+// had a chance to call flag.Parse().
+var (
+	rtcpReconcileInterval time.Duration
+	rtcpReconcileTTL      time.Duration
+)
+
+func init() {
+	flag.DurationVar(&rtcpReconcileInterval, "rtcp-reconcile-interval", 5*time.Second,
+		"How often to retry correlation for RTCP that arrived before its SDP")
+	flag.DurationVar(&rtcpReconcileTTL, "rtcp-reconcile-ttl", 30*time.Second,
+		"How long an un-correlated RTCP stream is kept pending before being dropped")
+	go runRTCPReconciler()
+}
+
+// pendingRTCPEntry is a buffered RTCP report that arrived before its Call-ID
+// could be resolved, most commonly because the RTCP hit the wire before the
+// SDP 200 OK (or PRACK/UPDATE carrying SDP) was parsed.
+type pendingRTCPEntry struct {
+	jsonRTCP  []byte
+	srcIP     net.IP
+	srcPort   uint16
+	dstIP     net.IP
+	dstPort   uint16
+	firstSeen time.Time
+}
+
+// ReconciledRTCP is a previously buffered RTCP payload that the reconciliation
+// sweep has since matched to a Call-ID.
+type ReconciledRTCP struct {
+	JSON   []byte
+	CallID []byte
+}
+
+var (
+	pendingMu        sync.Mutex
+	pendingRTCP      = make(map[string]*pendingRTCPEntry)
+	pendingRTCPOrder []string
+)
+
+// bufferPendingRTCP records an RTCP report that correlateRTCP could not match
+// to a Call-ID, keyed by SSRC, so the reconciliation sweep can retry it once
+// the SDP that describes its stream has been parsed.
+func bufferPendingRTCP(ssrc, jsonRTCP []byte, srcIP net.IP, srcPort uint16, dstIP net.IP, dstPort uint16) {
+	if ssrc == nil {
+		return
+	}
+
+	pendingMu.Lock()
+	defer pendingMu.Unlock()
+
+	key := string(ssrc)
+	firstSeen := time.Now()
+	if existing, ok := pendingRTCP[key]; ok {
+		firstSeen = existing.firstSeen
+	} else {
+		if len(pendingRTCPOrder) >= pendingRTCPCap {
+			oldest := pendingRTCPOrder[0]
+			pendingRTCPOrder = pendingRTCPOrder[1:]
+			delete(pendingRTCP, oldest)
+		}
+		pendingRTCPOrder = append(pendingRTCPOrder, key)
+	}
+	pendingRTCP[key] = &pendingRTCPEntry{
+		jsonRTCP:  jsonRTCP,
+		srcIP:     srcIP,
+		srcPort:   srcPort,
+		dstIP:     dstIP,
+		dstPort:   dstPort,
+		firstSeen: firstSeen,
+	}
+}
+
+// runRTCPReconciler is the goroutine started from this file's init, in the
+// same spirit as updateSocketMap's periodic recovery from missed kernel
+// events: every rtcpReconcileInterval it retries correlation for RTCP
+// streams that arrived before their SDP did (forking, delayed answer,
+// PRACK/UPDATE with SDP), and reports every match to EmitHEPEvent. It never
+// returns.
+func runRTCPReconciler() {
+	for {
+		interval := rtcpReconcileInterval
+		if interval <= 0 {
+			interval = 5 * time.Second
+		}
+		time.Sleep(interval)
+
+		ttl := rtcpReconcileTTL
+		if ttl <= 0 {
+			ttl = 30 * time.Second
+		}
+		for _, m := range reconcileRTCP(ttl) {
+			EmitHEPEvent(HEPEvent{Subtype: 5, JSON: m.JSON, CallID: m.CallID})
+		}
+	}
+}
+
+// reconcileRTCP retries every buffered SSRC against the current sdpCache,
+// returning whatever now correlates and expiring whatever has outlived ttl
+// without a match.
+func reconcileRTCP(ttl time.Duration) []ReconciledRTCP {
+	var matches []ReconciledRTCP
+
+	pendingMu.Lock()
+	now := time.Now()
+	remaining := pendingRTCPOrder[:0]
+	for _, key := range pendingRTCPOrder {
+		entry, ok := pendingRTCP[key]
+		if !ok {
+			continue
+		}
+		if now.Sub(entry.firstSeen) > ttl {
+			delete(pendingRTCP, key)
+			logp.Debug("rtcp", "Expired pending RTCP for ssrc=%x after %s", key, ttl)
+			continue
+		}
+
+		srcKey := []byte(entry.srcIP.String() + strconv.Itoa(int(entry.srcPort)))
+		dstKey := []byte(entry.dstIP.String() + strconv.Itoa(int(entry.dstPort)))
+		corrID := sdpCache.Get(nil, srcKey)
+		if corrID == nil {
+			corrID = sdpCache.Get(nil, dstKey)
+		}
+		if corrID == nil {
+			remaining = append(remaining, key)
+			continue
+		}
+
+		rtcpCache.Set([]byte(key), corrID)
+		delete(pendingRTCP, key)
+		logp.Debug("rtcp", "Late correlation for srcIP=%s, dstIP=%s, callid=%s", entry.srcIP, entry.dstIP, corrID)
+		matches = append(matches, ReconciledRTCP{JSON: entry.jsonRTCP, CallID: corrID})
+	}
+	pendingRTCPOrder = remaining
+	pendingMu.Unlock()
+
+	return matches
+}
@@ -0,0 +1,160 @@
+package decoder
+
+import (
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// resetPendingRTCP clears the package-level pending RTCP state so tests don't
+// see entries left behind by runRTCPReconciler (started from this package's
+// init) or by other tests, and restores whatever was there afterwards.
+func resetPendingRTCP(t *testing.T) {
+	t.Helper()
+	pendingMu.Lock()
+	prevPending, prevOrder := pendingRTCP, pendingRTCPOrder
+	pendingRTCP = make(map[string]*pendingRTCPEntry)
+	pendingRTCPOrder = nil
+	pendingMu.Unlock()
+	t.Cleanup(func() {
+		pendingMu.Lock()
+		pendingRTCP, pendingRTCPOrder = prevPending, prevOrder
+		pendingMu.Unlock()
+	})
+}
+
+func TestBufferPendingRTCPPreservesFirstSeenAcrossUpdates(t *testing.T) {
+	resetPendingRTCP(t)
+
+	ssrc := []byte{0x01, 0x02, 0x03, 0x04}
+	srcIP := net.ParseIP("198.51.100.5")
+	dstIP := net.ParseIP("198.51.100.6")
+
+	bufferPendingRTCP(ssrc, []byte(`{"a":1}`), srcIP, 1000, dstIP, 2000)
+
+	pendingMu.Lock()
+	firstSeen := pendingRTCP[string(ssrc)].firstSeen
+	pendingMu.Unlock()
+
+	// A second RTCP report for the same still-uncorrelated SSRC must not
+	// reset firstSeen, or it would never age past ttl in reconcileRTCP.
+	bufferPendingRTCP(ssrc, []byte(`{"a":2}`), srcIP, 1000, dstIP, 2000)
+
+	pendingMu.Lock()
+	entry := pendingRTCP[string(ssrc)]
+	pendingMu.Unlock()
+
+	if !entry.firstSeen.Equal(firstSeen) {
+		t.Fatalf("expected firstSeen to be preserved across updates, got %v want %v", entry.firstSeen, firstSeen)
+	}
+	if string(entry.jsonRTCP) != `{"a":2}` {
+		t.Fatalf("expected the buffered payload to still be refreshed, got %s", entry.jsonRTCP)
+	}
+}
+
+func TestReconcileRTCPExpiresAfterTTL(t *testing.T) {
+	resetPendingRTCP(t)
+
+	ssrc := []byte{0x11, 0x12, 0x13, 0x14}
+	bufferPendingRTCP(ssrc, []byte(`{}`), net.ParseIP("198.51.100.7"), 1000, net.ParseIP("198.51.100.8"), 2000)
+
+	pendingMu.Lock()
+	pendingRTCP[string(ssrc)].firstSeen = time.Now().Add(-time.Hour)
+	pendingMu.Unlock()
+
+	matches := reconcileRTCP(time.Minute)
+	if len(matches) != 0 {
+		t.Fatalf("expected no matches for an expired entry, got %+v", matches)
+	}
+
+	pendingMu.Lock()
+	_, stillPending := pendingRTCP[string(ssrc)]
+	orderLen := len(pendingRTCPOrder)
+	pendingMu.Unlock()
+	if stillPending {
+		t.Fatal("expected the expired entry to be dropped from pendingRTCP")
+	}
+	if orderLen != 0 {
+		t.Fatalf("expected the expired entry to be dropped from pendingRTCPOrder, got %d entries left", orderLen)
+	}
+}
+
+func TestReconcileRTCPDrainsLateMatch(t *testing.T) {
+	resetPendingRTCP(t)
+
+	ssrc := []byte{0x21, 0x22, 0x23, 0x24}
+	srcIP := net.ParseIP("198.51.100.9")
+	srcPort := uint16(3000)
+	callID := []byte("late-match-1")
+
+	bufferPendingRTCP(ssrc, []byte(`{"ssrc":"late"}`), srcIP, srcPort, net.ParseIP("198.51.100.10"), 4000)
+
+	// The SDP that describes this stream only shows up after the RTCP did.
+	srcKey := []byte(srcIP.String() + strconv.Itoa(int(srcPort)))
+	sdpCache.Set(srcKey, callID)
+	defer sdpCache.Del(srcKey)
+
+	matches := reconcileRTCP(time.Minute)
+	if len(matches) != 1 {
+		t.Fatalf("expected exactly one late match, got %+v", matches)
+	}
+	if string(matches[0].CallID) != "late-match-1" || string(matches[0].JSON) != `{"ssrc":"late"}` {
+		t.Fatalf("unexpected match contents: %+v", matches[0])
+	}
+
+	pendingMu.Lock()
+	_, stillPending := pendingRTCP[string(ssrc)]
+	pendingMu.Unlock()
+	if stillPending {
+		t.Fatal("expected the drained entry to be removed from pendingRTCP")
+	}
+
+	if corrID := rtcpCache.Get(nil, ssrc); string(corrID) != "late-match-1" {
+		t.Fatalf("expected the late match to also populate rtcpCache, got %q", corrID)
+	}
+}
+
+func TestBufferPendingRTCPEvictsOldestAtCap(t *testing.T) {
+	resetPendingRTCP(t)
+
+	ip := net.ParseIP("198.51.100.11")
+	firstSSRC := []byte{0xff, 0xff, 0xff, 0x00}
+	bufferPendingRTCP(firstSSRC, []byte(`{}`), ip, 5000, ip, 5001)
+
+	for i := 1; i < pendingRTCPCap; i++ {
+		ssrc := []byte{0xff, 0xff, byte(i >> 8), byte(i)}
+		bufferPendingRTCP(ssrc, []byte(`{}`), ip, 5000, ip, 5001)
+	}
+
+	pendingMu.Lock()
+	_, stillPending := pendingRTCP[string(firstSSRC)]
+	orderLen := len(pendingRTCPOrder)
+	pendingMu.Unlock()
+	if !stillPending {
+		t.Fatal("did not expect eviction before reaching pendingRTCPCap")
+	}
+	if orderLen != pendingRTCPCap {
+		t.Fatalf("expected exactly pendingRTCPCap entries, got %d", orderLen)
+	}
+
+	// One more distinct SSRC pushes past the cap; the oldest must be evicted.
+	overflowSSRC := []byte{0xaa, 0xbb, 0xcc, 0xdd}
+	bufferPendingRTCP(overflowSSRC, []byte(`{}`), ip, 5000, ip, 5001)
+
+	pendingMu.Lock()
+	_, firstStillPending := pendingRTCP[string(firstSSRC)]
+	_, overflowPending := pendingRTCP[string(overflowSSRC)]
+	orderLen = len(pendingRTCPOrder)
+	pendingMu.Unlock()
+
+	if firstStillPending {
+		t.Fatal("expected the oldest entry to be evicted once pendingRTCPCap was exceeded")
+	}
+	if !overflowPending {
+		t.Fatal("expected the new entry to be tracked after eviction")
+	}
+	if orderLen != pendingRTCPCap {
+		t.Fatalf("expected pendingRTCPOrder to stay at pendingRTCPCap, got %d", orderLen)
+	}
+}
@@ -0,0 +1,85 @@
+package decoder
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"net"
+	"time"
+
+	"github.com/VictoriaMetrics/fastcache"
+	"github.com/negbie/logp"
+)
+
+var (
+	sdpResolve    bool
+	sdpResolveTTL time.Duration
+)
+
+func init() {
+	flag.BoolVar(&sdpResolve, "sdp-resolve", false, "Resolve non-numeric SDP connection addresses (FQDNs) via DNS before caching them")
+	flag.DurationVar(&sdpResolveTTL, "sdp-resolve-ttl", 5*time.Minute, "TTL for cached SDP hostname resolutions")
+}
+
+// hostResolver looks up the A/AAAA records of a hostname. It exists so tests
+// can stub DNS lookups without touching the network.
+type hostResolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+var resolver hostResolver = net.DefaultResolver
+
+var resolveCache = fastcache.New(1 * 1024 * 1024)
+
+// cachedIPs is the resolveCache value: a resolved A/AAAA set plus the time it
+// expires, since fastcache has no built-in per-entry TTL.
+type cachedIPs struct {
+	IPs     []string  `json:"ips"`
+	Expires time.Time `json:"expires"`
+}
+
+// resolveSDPHost resolves a non-numeric SDP connection address (RFC 4566
+// allows FQDNs in c= lines) to its A/AAAA records, memoizing the result for
+// sdpResolveTTL. It returns nil - meaning "use the address as-is" - when
+// resolution is disabled via -sdp-resolve, the address is already an IP
+// literal, or the lookup fails.
+func resolveSDPHost(host []byte) [][]byte {
+	if !sdpResolve {
+		return nil
+	}
+	if ip := net.ParseIP(string(host)); ip != nil {
+		return nil
+	}
+
+	if raw := resolveCache.Get(nil, host); raw != nil {
+		var cached cachedIPs
+		if err := json.Unmarshal(raw, &cached); err == nil && time.Now().Before(cached.Expires) {
+			return toByteIPs(cached.IPs)
+		}
+	}
+
+	addrs, err := resolver.LookupIPAddr(context.Background(), string(host))
+	if err != nil {
+		logp.Debug("sdp", "Failed to resolve SDP host '%s': %v", host, err)
+		return nil
+	}
+
+	ips := make([]string, 0, len(addrs))
+	for _, a := range addrs {
+		ips = append(ips, a.IP.String())
+	}
+
+	cached := cachedIPs{IPs: ips, Expires: time.Now().Add(sdpResolveTTL)}
+	if data, err := json.Marshal(cached); err == nil {
+		resolveCache.Set(host, data)
+	}
+	return toByteIPs(ips)
+}
+
+func toByteIPs(ips []string) [][]byte {
+	out := make([][]byte, len(ips))
+	for i, ip := range ips {
+		out[i] = []byte(ip)
+	}
+	return out
+}
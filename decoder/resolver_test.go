@@ -0,0 +1,105 @@
+package decoder
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+// fakeResolver is the stubbed hostResolver used so these tests never touch
+// real DNS; it records how many lookups were made in total, across all hosts.
+type fakeResolver struct {
+	lookups int
+	addrs   map[string][]net.IPAddr
+	err     error
+}
+
+func (f *fakeResolver) LookupIPAddr(_ context.Context, host string) ([]net.IPAddr, error) {
+	f.lookups++
+	if f.err != nil {
+		return nil, f.err
+	}
+	return f.addrs[host], nil
+}
+
+func withStubResolver(t *testing.T, fr *fakeResolver, ttl time.Duration) {
+	t.Helper()
+	prevResolver, prevEnabled, prevTTL := resolver, sdpResolve, sdpResolveTTL
+	resolver, sdpResolve, sdpResolveTTL = fr, true, ttl
+	t.Cleanup(func() {
+		resolver, sdpResolve, sdpResolveTTL = prevResolver, prevEnabled, prevTTL
+	})
+}
+
+func TestResolveSDPHostDisabled(t *testing.T) {
+	prevEnabled := sdpResolve
+	sdpResolve = false
+	defer func() { sdpResolve = prevEnabled }()
+
+	if ips := resolveSDPHost([]byte("sbc.example.com")); ips != nil {
+		t.Fatalf("expected no resolution when disabled, got %v", ips)
+	}
+}
+
+func TestResolveSDPHostNumericAddress(t *testing.T) {
+	fr := &fakeResolver{addrs: map[string][]net.IPAddr{}}
+	withStubResolver(t, fr, time.Minute)
+
+	if ips := resolveSDPHost([]byte("198.51.100.20")); ips != nil {
+		t.Fatalf("expected IP literals to skip resolution, got %v", ips)
+	}
+	if fr.lookups != 0 {
+		t.Fatalf("expected no DNS lookups for an IP literal, got %d", fr.lookups)
+	}
+}
+
+func TestResolveSDPHostResolvesAndCaches(t *testing.T) {
+	fr := &fakeResolver{addrs: map[string][]net.IPAddr{
+		"sbc.example.com": {{IP: net.ParseIP("198.51.100.30")}, {IP: net.ParseIP("2001:db8::30")}},
+	}}
+	withStubResolver(t, fr, time.Minute)
+
+	ips := resolveSDPHost([]byte("sbc.example.com"))
+	if len(ips) != 2 {
+		t.Fatalf("expected 2 resolved addresses, got %v", ips)
+	}
+	if fr.lookups != 1 {
+		t.Fatalf("expected exactly 1 DNS lookup, got %d", fr.lookups)
+	}
+
+	// Second call within the TTL must hit the cache, not the resolver again.
+	if ips := resolveSDPHost([]byte("sbc.example.com")); len(ips) != 2 {
+		t.Fatalf("expected cached result on second call, got %v", ips)
+	}
+	if fr.lookups != 1 {
+		t.Fatalf("expected cached lookup to avoid a second DNS query, got %d lookups", fr.lookups)
+	}
+}
+
+func TestResolveSDPHostExpiresAfterTTL(t *testing.T) {
+	fr := &fakeResolver{addrs: map[string][]net.IPAddr{
+		"ttl.example.com": {{IP: net.ParseIP("198.51.100.40")}},
+	}}
+	withStubResolver(t, fr, 10*time.Millisecond)
+
+	resolveSDPHost([]byte("ttl.example.com"))
+	if fr.lookups != 1 {
+		t.Fatalf("expected 1 DNS lookup, got %d", fr.lookups)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	resolveSDPHost([]byte("ttl.example.com"))
+	if fr.lookups != 2 {
+		t.Fatalf("expected cache to expire and re-resolve, got %d lookups", fr.lookups)
+	}
+}
+
+func TestResolveSDPHostLookupFailure(t *testing.T) {
+	fr := &fakeResolver{err: &net.DNSError{Err: "no such host", Name: "broken.example.com"}}
+	withStubResolver(t, fr, time.Minute)
+
+	if ips := resolveSDPHost([]byte("broken.example.com")); ips != nil {
+		t.Fatalf("expected nil on lookup failure, got %v", ips)
+	}
+}
@@ -0,0 +1,287 @@
+package decoder
+
+import (
+	"bytes"
+	"strconv"
+
+	"github.com/negbie/logp"
+)
+
+var (
+	cLine4   = []byte("c=IN IP4 ")
+	cLine6   = []byte("c=IN IP6 ")
+	mLine    = []byte("m=")
+	aRTCP    = []byte("a=rtcp:")
+	aCand    = []byte("a=candidate:")
+	aRTCPMux = []byte("a=rtcp-mux")
+	raddr    = []byte("raddr")
+	rport    = []byte("rport")
+)
+
+// sdpMedia holds the resolved RTP/RTCP endpoint of a single SDP media section
+// ("m=audio", "m=video", "m=application", ...). The media type itself isn't
+// kept: sdpCache is keyed by (ip, port) alone, and correlateRTCP only ever
+// knows the (ip, port) a packet arrived on, never which m= section it came
+// from, so there's nothing a stored media type could disambiguate.
+type sdpMedia struct {
+	ip       []byte
+	rtpPort  []byte
+	rtcpPort []byte
+}
+
+// iceCandidate holds one address+port harvested from an "a=candidate:" line,
+// the actual media endpoint for WebRTC and SIP-over-WebRTC offers that NAT
+// traversal means never appears in the c=/m= lines.
+type iceCandidate struct {
+	ip   []byte
+	port []byte
+}
+
+// cacheSDPIPPort will walk every media section of an SDP body, extract its
+// (ip, port) and RTCP companion port and cache them against the Call-ID found
+// in the SIP header. Unlike a single "c=IN IP4"/"m=audio" match, this covers
+// IPv6 connection addresses (including zone IDs) and any number of m= sections
+// (audio, video, application), each of which may carry its own c= line and
+// a=rtcp: override per RFC 4566 section 5.14. One sdpCache entry is written
+// per (ip, port) pair so RTCP on e.g. the video port is also correlated back
+// to the call. It also harvests ICE candidates (RFC 8839) so RTCP from behind
+// a symmetric NAT - whose address only ever appears in a=candidate: lines -
+// still correlates; both the candidate address and its raddr/rport reflexive
+// pair are cached, along with the matching RTCP port (the candidate's own
+// port for component=2, or port+1 for component=1 unless a=rtcp-mux is
+// present in the same media section). Connection addresses that are
+// hostnames rather than IP literals (FQDNs are allowed by RFC 4566) are
+// resolved via resolveSDPHost, gated by -sdp-resolve, with one sdpCache
+// entry written per resolved A/AAAA record.
+func cacheSDPIPPort(payload []byte) {
+	if bytes.Index(payload, []byte("c=IN IP")) < 0 {
+		return
+	}
+
+	callID := extractCallID(payload)
+	if callID == nil {
+		logp.Warn("No Call-ID in '%s'", payload)
+		return
+	}
+
+	sessionIP := parseConnLine(payload)
+
+	sections := splitMediaSections(payload)
+	if len(sections) == 0 {
+		logp.Debug("sdp", "No m= lines in SDP '%s'", payload)
+		return
+	}
+
+	for _, section := range sections {
+		ptMap := parsePTMap(section)
+
+		media := parseMediaSection(section, sessionIP)
+		if media != nil {
+			for _, ip := range resolveHost(media.ip) {
+				if media.rtpPort != nil {
+					key := append(append([]byte{}, ip...), media.rtpPort...)
+					sdpCache.Set(key, callID)
+					cachePTMap(key, ptMap)
+				}
+				if media.rtcpPort != nil {
+					sdpCache.Set(append(append([]byte{}, ip...), media.rtcpPort...), callID)
+				}
+			}
+		}
+
+		for _, cand := range parseICECandidates(section) {
+			for _, ip := range resolveHost(cand.ip) {
+				key := append(append([]byte{}, ip...), cand.port...)
+				sdpCache.Set(key, callID)
+				cachePTMap(key, ptMap)
+			}
+		}
+	}
+}
+
+// resolveHost returns the (possibly several) IPs a cached SDP address should
+// be keyed under: the address unchanged if it's already numeric or DNS
+// resolution via -sdp-resolve is off or fails, otherwise every resolved
+// A/AAAA record.
+func resolveHost(host []byte) [][]byte {
+	if ips := resolveSDPHost(host); ips != nil {
+		return ips
+	}
+	return [][]byte{host}
+}
+
+// parseICECandidates returns, for every "a=candidate:" line in a media
+// section, the candidate's own (ip, port) plus its RTCP companion port and,
+// where present, the raddr/rport reflexive pair with its own RTCP companion.
+// The RTCP companion is suppressed for component=1 candidates when the media
+// section negotiates rtcp-mux.
+func parseICECandidates(section []byte) []iceCandidate {
+	rtcpMux := bytes.Index(section, aRTCPMux) >= 0
+
+	var out []iceCandidate
+	for _, line := range bytes.Split(section, []byte("\r\n")) {
+		if !bytes.HasPrefix(line, aCand) {
+			continue
+		}
+		// a=candidate:foundation component transport priority ip port typ type [raddr ip rport port ...]
+		fields := bytes.Fields(line[len(aCand):])
+		if len(fields) < 6 {
+			logp.Debug("sdp", "Fishy ICE candidate in '%s'", line)
+			continue
+		}
+		component := fields[1]
+		out = append(out, candidateRTCP(fields[4], fields[5], component, rtcpMux)...)
+
+		for i := 6; i+3 < len(fields); i++ {
+			if bytes.Equal(fields[i], raddr) && bytes.Equal(fields[i+2], rport) {
+				out = append(out, candidateRTCP(fields[i+1], fields[i+3], component, rtcpMux)...)
+				break
+			}
+		}
+	}
+	return out
+}
+
+// candidateRTCP returns the candidate's own (ip, port) plus its RTCP
+// companion: the port itself for an RTCP (component=2) candidate, or port+1
+// for an RTP (component=1) candidate unless rtcp-mux suppresses it.
+func candidateRTCP(ip, port, component []byte, rtcpMux bool) []iceCandidate {
+	cands := []iceCandidate{{ip: ip, port: port}}
+	if bytes.Equal(component, []byte("2")) || rtcpMux {
+		return cands
+	}
+	p, err := strconv.Atoi(string(port))
+	if err != nil {
+		return cands
+	}
+	return append(cands, iceCandidate{ip: ip, port: []byte(strconv.Itoa(p + 1))})
+}
+
+// extractCallID returns the Call-ID (or compact "i:" form) out of a SIP message.
+func extractCallID(payload []byte) []byte {
+	if posCallID := bytes.Index(payload, []byte("Call-I")); posCallID > 0 {
+		restCallID := payload[posCallID:]
+		// Minimum Call-ID length of "Call-ID: a" = 10
+		if posRestCallID := bytes.Index(restCallID, []byte("\r\n")); posRestCallID >= 10 {
+			return bytes.TrimSpace(restCallID[len("Call-ID:"):posRestCallID])
+		}
+		logp.Debug("sdp", "No end or fishy Call-ID in '%s'", restCallID)
+		return nil
+	}
+	if posID := bytes.Index(payload, []byte("i: ")); posID > 0 {
+		restID := payload[posID:]
+		// Minimum Call-ID length of "i: a" = 4
+		if posRestID := bytes.Index(restID, []byte("\r\n")); posRestID >= 4 {
+			return bytes.TrimSpace(restID[len("i: "):posRestID])
+		}
+		logp.Debug("sdp", "No end or fishy Call-ID in '%s'", restID)
+	}
+	return nil
+}
+
+// splitMediaSections breaks an SDP body into one slice per "m=" line, each
+// holding that line and every attribute line that follows it up to the next
+// "m=" line.
+func splitMediaSections(payload []byte) [][]byte {
+	var sections [][]byte
+	var cur []byte
+	for _, line := range bytes.Split(payload, []byte("\r\n")) {
+		if bytes.HasPrefix(line, mLine) {
+			if cur != nil {
+				sections = append(sections, cur)
+			}
+			cur = append([]byte{}, line...)
+			continue
+		}
+		if cur != nil {
+			cur = append(cur, '\r', '\n')
+			cur = append(cur, line...)
+		}
+	}
+	if cur != nil {
+		sections = append(sections, cur)
+	}
+	return sections
+}
+
+// parseConnLine returns the session-level connection address, i.e. the c=
+// line that appears before the first m= line.
+func parseConnLine(payload []byte) []byte {
+	end := len(payload)
+	if m := bytes.Index(payload, mLine); m >= 0 {
+		end = m
+	}
+	return connAddr(payload[:end])
+}
+
+// connAddr extracts the address out of a "c=IN IP4 <addr>" or "c=IN IP6 <addr>"
+// line, the latter including bracketless IPv6 literals and zone IDs (e.g.
+// "fe80::1%eth0").
+func connAddr(section []byte) []byte {
+	pos := -1
+	prefix := cLine4
+	if i := bytes.Index(section, cLine4); i >= 0 {
+		pos = i
+	}
+	if i := bytes.Index(section, cLine6); i >= 0 && (pos < 0 || i < pos) {
+		pos, prefix = i, cLine6
+	}
+	if pos < 0 {
+		return nil
+	}
+	rest := section[pos+len(prefix):]
+	end := bytes.IndexAny(rest, "\r\n ")
+	if end < 0 {
+		end = len(rest)
+	}
+	addr := bytes.TrimSpace(rest[:end])
+	if len(addr) == 0 {
+		return nil
+	}
+	return addr
+}
+
+// parseMediaSection parses a single "m=" section, resolving its connection
+// address (its own c= line, falling back to the session-level one) and its
+// RTP/RTCP ports.
+func parseMediaSection(section []byte, sessionIP []byte) *sdpMedia {
+	if !bytes.HasPrefix(section, mLine) {
+		return nil
+	}
+	lineEnd := bytes.Index(section, []byte("\r\n"))
+	if lineEnd < 0 {
+		lineEnd = len(section)
+	}
+	fields := bytes.Fields(section[len(mLine):lineEnd])
+	if len(fields) < 2 {
+		logp.Debug("sdp", "No end or fishy SDP RTP Port in '%s'", section)
+		return nil
+	}
+
+	media := &sdpMedia{rtpPort: fields[1], ip: sessionIP}
+	if ip := connAddr(section); ip != nil {
+		media.ip = ip
+	}
+	if media.ip == nil {
+		logp.Debug("sdp", "No SDP IP for media section '%s'", section)
+		return nil
+	}
+
+	if pos := bytes.Index(section, aRTCP); pos >= 0 {
+		rest := section[pos+len(aRTCP):]
+		end := bytes.IndexAny(rest, "\r\n ")
+		if end < 0 {
+			end = len(rest)
+		}
+		media.rtcpPort = bytes.TrimSpace(rest[:end])
+		return media
+	}
+
+	port, err := strconv.Atoi(string(media.rtpPort))
+	if err != nil {
+		logp.Debug("sdp", "Fishy SDP RTP Port in '%s'", media.rtpPort)
+		return media
+	}
+	media.rtcpPort = []byte(strconv.Itoa(port + 1))
+	return media
+}
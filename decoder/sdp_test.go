@@ -0,0 +1,91 @@
+package decoder
+
+import "testing"
+
+func sipPayload(callID, sdp string) []byte {
+	return []byte("INVITE sip:bob@example.com SIP/2.0\r\n" +
+		"Call-ID: " + callID + "\r\n" +
+		"Content-Type: application/sdp\r\n" +
+		"\r\n" + sdp)
+}
+
+func TestCacheSDPIPPortIPv6(t *testing.T) {
+	sdp := "v=0\r\n" +
+		"o=- 1 1 IN IP6 2001:db8::1\r\n" +
+		"s=-\r\n" +
+		"c=IN IP6 2001:db8::1\r\n" +
+		"t=0 0\r\n" +
+		"m=audio 6000 RTP/AVP 0\r\n" +
+		"a=rtpmap:0 PCMU/8000\r\n"
+
+	cacheSDPIPPort(sipPayload("ipv6-audio-1", sdp))
+
+	if got := sdpCache.Get(nil, []byte("2001:db8::16000")); string(got) != "ipv6-audio-1" {
+		t.Fatalf("RTP endpoint not cached for IPv6 address, got %q", got)
+	}
+	if got := sdpCache.Get(nil, []byte("2001:db8::16001")); string(got) != "ipv6-audio-1" {
+		t.Fatalf("RTCP endpoint (RTP port + 1) not cached for IPv6 address, got %q", got)
+	}
+}
+
+func TestCacheSDPIPPortHoldUnhold(t *testing.T) {
+	initial := "v=0\r\n" +
+		"o=- 1 1 IN IP4 198.51.100.1\r\n" +
+		"s=-\r\n" +
+		"c=IN IP4 198.51.100.1\r\n" +
+		"t=0 0\r\n" +
+		"m=audio 7000 RTP/AVP 0\r\n"
+	cacheSDPIPPort(sipPayload("hold-unhold-1", initial))
+	if got := sdpCache.Get(nil, []byte("198.51.100.17000")); string(got) != "hold-unhold-1" {
+		t.Fatalf("initial offer endpoint not cached, got %q", got)
+	}
+
+	// Hold re-INVITE: connection address changes to the hold sentinel.
+	hold := "v=0\r\n" +
+		"o=- 1 2 IN IP4 198.51.100.1\r\n" +
+		"s=-\r\n" +
+		"c=IN IP4 0.0.0.0\r\n" +
+		"t=0 0\r\n" +
+		"m=audio 7000 RTP/AVP 0\r\n"
+	cacheSDPIPPort(sipPayload("hold-unhold-1", hold))
+	if got := sdpCache.Get(nil, []byte("0.0.0.07000")); string(got) != "hold-unhold-1" {
+		t.Fatalf("hold endpoint not cached, got %q", got)
+	}
+
+	// Unhold re-INVITE: connection address moves to a new media server.
+	unhold := "v=0\r\n" +
+		"o=- 1 3 IN IP4 198.51.100.1\r\n" +
+		"s=-\r\n" +
+		"c=IN IP4 198.51.100.9\r\n" +
+		"t=0 0\r\n" +
+		"m=audio 7002 RTP/AVP 0\r\n"
+	cacheSDPIPPort(sipPayload("hold-unhold-1", unhold))
+	if got := sdpCache.Get(nil, []byte("198.51.100.97002")); string(got) != "hold-unhold-1" {
+		t.Fatalf("unhold endpoint not cached, got %q", got)
+	}
+}
+
+func TestCacheSDPIPPortMultiStream(t *testing.T) {
+	sdp := "v=0\r\n" +
+		"o=- 1 1 IN IP4 203.0.113.5\r\n" +
+		"s=-\r\n" +
+		"c=IN IP4 203.0.113.5\r\n" +
+		"t=0 0\r\n" +
+		"m=audio 8000 RTP/AVP 0\r\n" +
+		"a=rtpmap:0 PCMU/8000\r\n" +
+		"m=video 8010 RTP/AVP 96\r\n" +
+		"a=rtpmap:96 VP8/90000\r\n" +
+		"m=application 8020 UDP/DTLS/SCTP webrtc-datachannel\r\n"
+
+	cacheSDPIPPort(sipPayload("multistream-1", sdp))
+
+	for _, key := range []string{
+		"203.0.113.58000", "203.0.113.58001",
+		"203.0.113.58010", "203.0.113.58011",
+		"203.0.113.58020", "203.0.113.58021",
+	} {
+		if got := sdpCache.Get(nil, []byte(key)); string(got) != "multistream-1" {
+			t.Errorf("endpoint %s not cached to multistream-1, got %q", key, got)
+		}
+	}
+}